@@ -8,9 +8,10 @@ import (
 
 // ServerConfig 服务器配置
 type ServerConfig struct {
-	Port     int    `json:"port"`
-	CertFile string `json:"cert_file"`
-	KeyFile  string `json:"key_file"`
+	Port       int    `json:"port"`
+	CertFile   string `json:"cert_file"`
+	KeyFile    string `json:"key_file"`
+	AdminToken string `json:"admin_token"`
 }
 
 // DatabaseConfig 数据库配置
@@ -22,10 +23,24 @@ type DatabaseConfig struct {
 	DBName   string `json:"dbname"`
 }
 
+// FXRateEntry 配置文件中的静态汇率条目
+type FXRateEntry struct {
+	Base  string  `json:"base"`
+	Quote string  `json:"quote"`
+	Rate  float64 `json:"rate"`
+}
+
+// CurrencyConfig 多币种与汇率相关配置
+type CurrencyConfig struct {
+	Whitelist []string      `json:"whitelist"`
+	FXRates   []FXRateEntry `json:"fx_rates"`
+}
+
 // Config 总配置
 type Config struct {
 	Server   ServerConfig   `json:"server"`
 	Database DatabaseConfig `json:"database"`
+	Currency CurrencyConfig `json:"currency"`
 }
 
 var GlobalConfig *Config
@@ -39,10 +39,11 @@ func InitDB(dsn string) error {
 
 // createTables 自动创建数据表
 func createTables() error {
+	// balance 为有符号整数：信用账户（见 credit_accounts）允许透支至负值
 	createAccountsTable := `
 	CREATE TABLE IF NOT EXISTS accounts (
 		uuid VARCHAR(36) PRIMARY KEY,
-		balance BIGINT UNSIGNED NOT NULL DEFAULT 0,
+		balance BIGINT NOT NULL DEFAULT 0,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
 	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -53,10 +54,196 @@ func createTables() error {
 		return fmt.Errorf("创建accounts表失败: %v", err)
 	}
 
+	// transactions 记录每一笔业务操作（存款/取款/转账等），真正的发生来源
+	createTransactionsTable := `
+	CREATE TABLE IF NOT EXISTS transactions (
+		id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+		kind VARCHAR(32) NOT NULL,
+		memo VARCHAR(255) NOT NULL DEFAULT '',
+		request_id VARCHAR(64) DEFAULT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+
+	_, err = DB.Exec(createTransactionsTable)
+	if err != nil {
+		return fmt.Errorf("创建transactions表失败: %v", err)
+	}
+
+	// postings 是不可变的复式记账分录，账户余额由其汇总派生
+	createPostingsTable := `
+	CREATE TABLE IF NOT EXISTS postings (
+		id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+		tx_id BIGINT UNSIGNED NOT NULL,
+		account_uuid VARCHAR(36) NOT NULL,
+		direction ENUM('debit', 'credit') NOT NULL,
+		amount BIGINT UNSIGNED NOT NULL,
+		currency CHAR(3) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_postings_account (account_uuid, id),
+		INDEX idx_postings_tx (tx_id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+
+	_, err = DB.Exec(createPostingsTable)
+	if err != nil {
+		return fmt.Errorf("创建postings表失败: %v", err)
+	}
+
+	// api_clients 保存注册的调用方，共享密钥仅以哈希形式落盘
+	createAPIClientsTable := `
+	CREATE TABLE IF NOT EXISTS api_clients (
+		client_id VARCHAR(64) PRIMARY KEY,
+		secret_hash CHAR(64) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+
+	_, err = DB.Exec(createAPIClientsTable)
+	if err != nil {
+		return fmt.Errorf("创建api_clients表失败: %v", err)
+	}
+
+	// account_balances 是账户按币种拆分的余额缓存，由 postings 汇总派生
+	// balance 为有符号整数：信用账户允许透支至负值
+	createAccountBalancesTable := `
+	CREATE TABLE IF NOT EXISTS account_balances (
+		uuid VARCHAR(36) NOT NULL,
+		currency CHAR(3) NOT NULL,
+		balance BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (uuid, currency)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+
+	_, err = DB.Exec(createAccountBalancesTable)
+	if err != nil {
+		return fmt.Errorf("创建account_balances表失败: %v", err)
+	}
+
+	// fx_rates 保存每次查得的汇率快照，供审计与重算使用
+	createFXRatesTable := `
+	CREATE TABLE IF NOT EXISTS fx_rates (
+		id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+		base CHAR(3) NOT NULL,
+		quote CHAR(3) NOT NULL,
+		rate DECIMAL(18, 8) NOT NULL,
+		fetched_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_fx_rates_pair (base, quote, fetched_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+
+	_, err = DB.Exec(createFXRatesTable)
+	if err != nil {
+		return fmt.Errorf("创建fx_rates表失败: %v", err)
+	}
+
+	// credit_accounts 标记一个账户为信用（后付费）账户及其账单周期配置
+	createCreditAccountsTable := `
+	CREATE TABLE IF NOT EXISTS credit_accounts (
+		uuid VARCHAR(36) PRIMARY KEY,
+		credit_limit BIGINT UNSIGNED NOT NULL DEFAULT 0,
+		billing_cycle ENUM('weekly', 'monthly') NOT NULL,
+		cycle_anchor DATE NOT NULL,
+		grace_days INT NOT NULL DEFAULT 0
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+
+	_, err = DB.Exec(createCreditAccountsTable)
+	if err != nil {
+		return fmt.Errorf("创建credit_accounts表失败: %v", err)
+	}
+
+	// credit_statements 是信用账户按账单周期生成的结算记录
+	createCreditStatementsTable := `
+	CREATE TABLE IF NOT EXISTS credit_statements (
+		id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+		uuid VARCHAR(36) NOT NULL,
+		period_start TIMESTAMP NOT NULL,
+		period_end TIMESTAMP NOT NULL,
+		opening_balance BIGINT NOT NULL DEFAULT 0,
+		charges BIGINT UNSIGNED NOT NULL DEFAULT 0,
+		payments BIGINT UNSIGNED NOT NULL DEFAULT 0,
+		closing_balance BIGINT NOT NULL DEFAULT 0,
+		due_date TIMESTAMP NULL DEFAULT NULL,
+		status ENUM('open', 'closed', 'paid') NOT NULL DEFAULT 'open',
+		INDEX idx_credit_statements_uuid (uuid, status)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+
+	_, err = DB.Exec(createCreditStatementsTable)
+	if err != nil {
+		return fmt.Errorf("创建credit_statements表失败: %v", err)
+	}
+
+	// CREATE TABLE IF NOT EXISTS 不会改动已存在的表结构，旧版本部署的idempotency_keys仍是单列主键
+	// `key`，需要先迁移到(client_id, key)联合主键，否则不同客户端复用同一字面key会互相冲突
+	if err := migrateIdempotencyKeysSchema(); err != nil {
+		return fmt.Errorf("迁移idempotency_keys表结构失败: %v", err)
+	}
+
+	// idempotency_keys 保存写操作的幂等结果，供相同客户端以相同Idempotency-Key重放时直接返回
+	// key以(client_id, key)联合作为主键：不同客户端复用同一枚字面key互不影响
+	// response_status为0代表该记录尚在处理中（占位），处理完成后由CompleteIdempotencyRecord写回真实状态码
+	createIdempotencyKeysTable := `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		client_id VARCHAR(64) NOT NULL,
+		` + "`key`" + ` VARCHAR(64) NOT NULL,
+		request_hash CHAR(64) NOT NULL,
+		response_status INT NOT NULL DEFAULT 0,
+		response_body MEDIUMTEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (client_id, ` + "`key`" + `),
+		INDEX idx_idempotency_keys_expires (expires_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+
+	_, err = DB.Exec(createIdempotencyKeysTable)
+	if err != nil {
+		return fmt.Errorf("创建idempotency_keys表失败: %v", err)
+	}
+
 	log.Println("数据表检查/创建完成")
 	return nil
 }
 
+// migrateIdempotencyKeysSchema 将旧版本单列主键(`key`)的idempotency_keys表升级为(client_id, key)联合主键，
+// 表不存在或已是联合主键时不做任何事
+func migrateIdempotencyKeysSchema() error {
+	var tableExists int
+	if err := DB.QueryRow(
+		"SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'idempotency_keys'",
+	).Scan(&tableExists); err != nil {
+		return fmt.Errorf("检查idempotency_keys表是否存在失败: %v", err)
+	}
+	if tableExists == 0 {
+		return nil
+	}
+
+	var pkColumns int
+	if err := DB.QueryRow(
+		"SELECT COUNT(*) FROM information_schema.KEY_COLUMN_USAGE WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'idempotency_keys' AND CONSTRAINT_NAME = 'PRIMARY'",
+	).Scan(&pkColumns); err != nil {
+		return fmt.Errorf("检查idempotency_keys主键失败: %v", err)
+	}
+	if pkColumns > 1 {
+		// 已是联合主键，无需迁移
+		return nil
+	}
+
+	alterSQL := "ALTER TABLE idempotency_keys ADD PRIMARY KEY (client_id, `key`), MODIFY response_status INT NOT NULL DEFAULT 0"
+	if pkColumns == 1 {
+		// 存在旧版本单列主键，需要先DROP掉才能建立新的联合主键
+		alterSQL = "ALTER TABLE idempotency_keys DROP PRIMARY KEY, ADD PRIMARY KEY (client_id, `key`), MODIFY response_status INT NOT NULL DEFAULT 0"
+	}
+
+	if _, err := DB.Exec(alterSQL); err != nil {
+		return fmt.Errorf("升级idempotency_keys主键失败: %v", err)
+	}
+
+	return nil
+}
+
 // CloseDB 关闭数据库连接
 func CloseDB() {
 	if DB != nil {
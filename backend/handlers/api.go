@@ -1,12 +1,16 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"bamsystem-backend/config"
 	"bamsystem-backend/models"
@@ -14,6 +18,10 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// maxAmount 金额上限：超过int64可表示范围的金额在models层会被错误地cast为负数，
+// 从而在余额/透支校验中翻转符号，必须在进入models之前拒绝
+const maxAmount = uint64(math.MaxInt64)
+
 // StandardResponse 标准响应
 type StandardResponse struct {
 	Success bool   `json:"success"`
@@ -22,9 +30,10 @@ type StandardResponse struct {
 }
 
 // BalanceResponse 带余额的响应
+// Balance 为有符号整数：信用账户透支时余额可能为负
 type BalanceResponse struct {
 	Success bool   `json:"success"`
-	Balance uint64 `json:"balance,omitempty"`
+	Balance int64  `json:"balance,omitempty"`
 	Message string `json:"message,omitempty"`
 	Error   string `json:"error,omitempty"`
 }
@@ -33,6 +42,7 @@ type BalanceResponse struct {
 type CreateAccountRequest struct {
 	UUID      string `json:"uuid"`
 	Balance   uint64 `json:"balance"`
+	Currency  string `json:"currency"`
 	Timestamp int64  `json:"timestamp"`
 }
 
@@ -40,6 +50,7 @@ type CreateAccountRequest struct {
 type DepositRequest struct {
 	UUID      string `json:"uuid"`
 	Amount    uint64 `json:"amount"`
+	Currency  string `json:"currency"`
 	Timestamp int64  `json:"timestamp"`
 }
 
@@ -47,24 +58,57 @@ type DepositRequest struct {
 type WithdrawRequest struct {
 	UUID      string `json:"uuid"`
 	Amount    uint64 `json:"amount"`
+	Currency  string `json:"currency"`
 	Timestamp int64  `json:"timestamp"`
 }
 
 // TransferRequest 转账请求
 type TransferRequest struct {
-	UUIDFrom  string `json:"uuid_from"`
-	UUIDTo    string `json:"uuid_to"`
-	Amount    uint64 `json:"amount"`
-	Timestamp int64  `json:"timestamp"`
+	UUIDFrom       string `json:"uuid_from"`
+	UUIDTo         string `json:"uuid_to"`
+	Amount         uint64 `json:"amount"`
+	Currency       string `json:"currency"`
+	TargetCurrency string `json:"target_currency,omitempty"`
+	Timestamp      int64  `json:"timestamp"`
 }
 
 // SyncAccountRequest 同步账户请求
 type SyncAccountRequest struct {
 	UUID      string `json:"uuid"`
 	Balance   uint64 `json:"balance"`
+	Currency  string `json:"currency"`
 	Timestamp int64  `json:"timestamp"`
 }
 
+// RegisterCreditAccountRequest 开通信用账户请求
+type RegisterCreditAccountRequest struct {
+	UUID         string `json:"uuid"`
+	CreditLimit  uint64 `json:"credit_limit"`
+	BillingCycle string `json:"billing_cycle"`
+	GraceDays    int    `json:"grace_days"`
+	Timestamp    int64  `json:"timestamp"`
+}
+
+// PayStatementRequest 信用账单还款请求
+type PayStatementRequest struct {
+	StatementID int64  `json:"statement_id"`
+	Amount      uint64 `json:"amount"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// CreateClientRequest 注册调用方请求
+type CreateClientRequest struct {
+	ClientID string `json:"client_id"`
+}
+
+// ClientSecretResponse 注册调用方响应，secret仅在此次返回中可见
+type ClientSecretResponse struct {
+	Success  bool   `json:"success"`
+	ClientID string `json:"client_id,omitempty"`
+	Secret   string `json:"secret,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
 // CheckServerHandler 检查服务器状态
 func CheckServerHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -91,8 +135,14 @@ func CreateAccountHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	currency := resolveCurrency(req.Currency)
+	if !models.IsCurrencyAllowed(currency) {
+		sendErrorResponse(w, "不支持的币种", http.StatusBadRequest)
+		return
+	}
+
 	// 创建账户
-	if err := models.CreateAccount(req.UUID, req.Balance); err != nil {
+	if err := models.CreateAccount(req.UUID, req.Balance, currency); err != nil {
 		log.Printf("创建账户失败: %v", err)
 		sendErrorResponse(w, "创建账户失败", http.StatusInternalServerError)
 		return
@@ -116,13 +166,19 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 验证金额
-	if req.Amount == 0 {
-		sendErrorResponse(w, "存款金额必须大于0", http.StatusBadRequest)
+	if req.Amount == 0 || req.Amount > maxAmount {
+		sendErrorResponse(w, "存款金额无效", http.StatusBadRequest)
+		return
+	}
+
+	currency := resolveCurrency(req.Currency)
+	if !models.IsCurrencyAllowed(currency) {
+		sendErrorResponse(w, "不支持的币种", http.StatusBadRequest)
 		return
 	}
 
 	// 执行存款
-	newBalance, err := models.Deposit(req.UUID, req.Amount)
+	newBalance, err := models.Deposit(req.UUID, req.Amount, currency)
 	if err != nil {
 		log.Printf("存款失败: %v", err)
 		if strings.Contains(err.Error(), "不存在") {
@@ -151,17 +207,25 @@ func WithdrawHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 验证金额
-	if req.Amount == 0 {
-		sendErrorResponse(w, "取款金额必须大于0", http.StatusBadRequest)
+	if req.Amount == 0 || req.Amount > maxAmount {
+		sendErrorResponse(w, "取款金额无效", http.StatusBadRequest)
+		return
+	}
+
+	currency := resolveCurrency(req.Currency)
+	if !models.IsCurrencyAllowed(currency) {
+		sendErrorResponse(w, "不支持的币种", http.StatusBadRequest)
 		return
 	}
 
 	// 执行取款
-	newBalance, err := models.Withdraw(req.UUID, req.Amount)
+	newBalance, err := models.Withdraw(req.UUID, req.Amount, currency)
 	if err != nil {
 		log.Printf("取款失败: %v", err)
 		if strings.Contains(err.Error(), "余额不足") {
 			sendErrorResponse(w, "余额不足", http.StatusBadRequest)
+		} else if strings.Contains(err.Error(), "超出信用额度") {
+			sendErrorResponse(w, "超出信用额度", http.StatusBadRequest)
 		} else if strings.Contains(err.Error(), "不存在") {
 			sendErrorResponse(w, "账户不存在", http.StatusNotFound)
 		} else {
@@ -188,8 +252,8 @@ func TransferHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 验证金额
-	if req.Amount == 0 {
-		sendErrorResponse(w, "转账金额必须大于0", http.StatusBadRequest)
+	if req.Amount == 0 || req.Amount > maxAmount {
+		sendErrorResponse(w, "转账金额无效", http.StatusBadRequest)
 		return
 	}
 
@@ -199,11 +263,23 @@ func TransferHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	currency := resolveCurrency(req.Currency)
+	if !models.IsCurrencyAllowed(currency) {
+		sendErrorResponse(w, "不支持的币种", http.StatusBadRequest)
+		return
+	}
+	if req.TargetCurrency != "" && !models.IsCurrencyAllowed(req.TargetCurrency) {
+		sendErrorResponse(w, "不支持的目标币种", http.StatusBadRequest)
+		return
+	}
+
 	// 执行转账
-	if err := models.Transfer(req.UUIDFrom, req.UUIDTo, req.Amount); err != nil {
+	if err := models.Transfer(req.UUIDFrom, req.UUIDTo, currency, req.TargetCurrency, req.Amount); err != nil {
 		log.Printf("转账失败: %v", err)
 		if strings.Contains(err.Error(), "余额不足") {
 			sendErrorResponse(w, "转出账户余额不足", http.StatusBadRequest)
+		} else if strings.Contains(err.Error(), "超出信用额度") {
+			sendErrorResponse(w, "超出信用额度", http.StatusBadRequest)
 		} else if strings.Contains(err.Error(), "不存在") {
 			sendErrorResponse(w, err.Error(), http.StatusNotFound)
 		} else {
@@ -254,14 +330,27 @@ func SyncAccountHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 同步账户
-	if err := models.SyncAccount(req.UUID, req.Balance); err != nil {
+	currency := resolveCurrency(req.Currency)
+	if !models.IsCurrencyAllowed(currency) {
+		sendErrorResponse(w, "不支持的币种", http.StatusBadRequest)
+		return
+	}
+
+	// 同步账户：走账本记一笔调整交易，而非直接覆盖余额
+	newBalance, err := models.SyncAccount(req.UUID, int64(req.Balance), currency)
+	if err != nil {
 		log.Printf("同步账户失败: %v", err)
-		sendErrorResponse(w, "同步账户失败", http.StatusInternalServerError)
+		if strings.Contains(err.Error(), "不存在") {
+			sendErrorResponse(w, "账户不存在", http.StatusNotFound)
+		} else if strings.Contains(err.Error(), "余额不足") || strings.Contains(err.Error(), "超出信用额度") {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		} else {
+			sendErrorResponse(w, "同步账户失败", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	sendSuccessResponse(w, "账户数据已同步")
+	sendBalanceResponse(w, newBalance, "账户数据已同步")
 }
 
 // GetPublicKeyHandler 获取服务器公钥/证书
@@ -302,8 +391,250 @@ func GetAllAccountsHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// GetAccountHistoryHandler 查询账户的分录流水（分页）
+func GetAccountHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	if !isValidUUID(uuid) {
+		sendErrorResponse(w, "UUID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+
+	from := time.Unix(0, 0)
+	if v := query.Get("from"); v != "" {
+		ts, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			sendErrorResponse(w, "from参数格式错误", http.StatusBadRequest)
+			return
+		}
+		from = time.Unix(ts, 0)
+	}
+
+	to := time.Now()
+	if v := query.Get("to"); v != "" {
+		ts, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			sendErrorResponse(w, "to参数格式错误", http.StatusBadRequest)
+			return
+		}
+		to = time.Unix(ts, 0)
+	}
+
+	limit := 50
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			sendErrorResponse(w, "limit参数格式错误", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ledger := models.NewLedger()
+	postings, nextCursor, err := ledger.GetHistory(uuid, from, to, limit, query.Get("cursor"))
+	if err != nil {
+		log.Printf("查询账户流水失败: %v", err)
+		sendErrorResponse(w, "查询账户流水失败", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"postings":    postings,
+		"next_cursor": nextCursor,
+	})
+}
+
+// GetTransactionHandler 查询单笔交易及其分录
+func GetTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	txID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		sendErrorResponse(w, "交易ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	ledger := models.NewLedger()
+	transaction, postings, err := ledger.GetTransaction(txID)
+	if err != nil {
+		log.Printf("查询交易失败: %v", err)
+		if strings.Contains(err.Error(), "不存在") {
+			sendErrorResponse(w, "交易不存在", http.StatusNotFound)
+		} else {
+			sendErrorResponse(w, "查询交易失败", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"transaction": transaction,
+		"postings":    postings,
+	})
+}
+
+// RegisterCreditAccountHandler 开通信用账户
+func RegisterCreditAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var req RegisterCreditAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "请求参数错误", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidUUID(req.UUID) {
+		sendErrorResponse(w, "UUID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	cycle := models.BillingCycle(req.BillingCycle)
+	if err := models.RegisterCreditAccount(req.UUID, req.CreditLimit, cycle, time.Now(), req.GraceDays); err != nil {
+		log.Printf("开通信用账户失败: %v", err)
+		if strings.Contains(err.Error(), "不存在") {
+			sendErrorResponse(w, "账户不存在", http.StatusNotFound)
+		} else {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	sendSuccessResponse(w, "信用账户开通成功")
+}
+
+// GenerateStatementHandler 手动触发信用账户的账单结算
+func GenerateStatementHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	if !isValidUUID(uuid) {
+		sendErrorResponse(w, "UUID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	statement, err := models.GenerateStatement(r.Context(), uuid, true)
+	if err != nil {
+		log.Printf("结算信用账单失败: %v", err)
+		if strings.Contains(err.Error(), "不存在") {
+			sendErrorResponse(w, err.Error(), http.StatusNotFound)
+		} else {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"statement": statement,
+	})
+}
+
+// ListStatementsHandler 查询信用账户的账单列表
+func ListStatementsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	if !isValidUUID(uuid) {
+		sendErrorResponse(w, "UUID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	statements, err := models.ListStatements(uuid)
+	if err != nil {
+		log.Printf("查询信用账单列表失败: %v", err)
+		sendErrorResponse(w, "查询信用账单列表失败", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"statements": statements,
+	})
+}
+
+// PayStatementHandler 信用账单还款
+func PayStatementHandler(w http.ResponseWriter, r *http.Request) {
+	var req PayStatementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "请求参数错误", http.StatusBadRequest)
+		return
+	}
+
+	if req.Amount == 0 {
+		sendErrorResponse(w, "还款金额必须大于0", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.PayStatement(r.Context(), req.StatementID, req.Amount); err != nil {
+		log.Printf("信用账单还款失败: %v", err)
+		if strings.Contains(err.Error(), "不存在") {
+			sendErrorResponse(w, err.Error(), http.StatusNotFound)
+		} else {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	sendSuccessResponse(w, "还款成功")
+}
+
+// CreateClientHandler 注册调用方，由管理员通过X-Admin-Token令牌调用；该端点不经过AuthMiddleware的签名校验
+func CreateClientHandler(w http.ResponseWriter, r *http.Request) {
+	adminToken := config.GlobalConfig.Server.AdminToken
+	if adminToken == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(adminToken)) != 1 {
+		sendErrorResponse(w, "管理员令牌无效", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "请求参数错误", http.StatusBadRequest)
+		return
+	}
+	if req.ClientID == "" {
+		sendErrorResponse(w, "client_id不能为空", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := models.CreateAPIClient(req.ClientID)
+	if err != nil {
+		log.Printf("注册客户端失败: %v", err)
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			sendErrorResponse(w, "客户端已存在", http.StatusConflict)
+		} else {
+			sendErrorResponse(w, "注册客户端失败", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ClientSecretResponse{
+		Success:  true,
+		ClientID: req.ClientID,
+		Secret:   secret,
+	})
+}
+
 // 辅助函数
 
+// resolveCurrency 请求未显式指定币种时回退到默认币种
+func resolveCurrency(currency string) string {
+	if currency == "" {
+		return models.DefaultCurrency
+	}
+	return strings.ToUpper(currency)
+}
+
 // isValidUUID 验证UUID格式
 func isValidUUID(uuid string) bool {
 	// UUID v4 格式: xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx
@@ -333,7 +664,7 @@ func sendErrorResponse(w http.ResponseWriter, message string, statusCode int) {
 }
 
 // sendBalanceResponse 发送带余额的响应
-func sendBalanceResponse(w http.ResponseWriter, balance uint64, message string) {
+func sendBalanceResponse(w http.ResponseWriter, balance int64, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(BalanceResponse{
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"bamsystem-backend/config"
 	"bamsystem-backend/database"
 	"bamsystem-backend/handlers"
 	"bamsystem-backend/middleware"
+	"bamsystem-backend/models"
 
 	"github.com/gorilla/mux"
 )
@@ -24,6 +28,9 @@ func main() {
 		log.Fatalf("加载配置文件失败: %v", err)
 	}
 	log.Println("配置文件加载成功")
+	if config.GlobalConfig.Server.AdminToken == "" {
+		log.Println("警告: 未配置server.admin_token，/api/clients 将拒绝所有注册请求")
+	}
 
 	// 初始化数据库
 	dsn := config.GlobalConfig.Database.GetDSN()
@@ -32,14 +39,27 @@ func main() {
 	}
 	defer database.CloseDB()
 
+	// 加载币种白名单及静态汇率表
+	models.LoadCurrencyWhitelist(config.GlobalConfig.Currency.Whitelist)
+	staticRates := make(map[string]float64, len(config.GlobalConfig.Currency.FXRates))
+	for _, entry := range config.GlobalConfig.Currency.FXRates {
+		staticRates[entry.Base+"/"+entry.Quote] = entry.Rate
+	}
+	models.SetRateProvider(models.NewStaticRateProvider(staticRates))
+
+	// 启动信用账户账单周期调度器
+	go runCreditStatementScheduler()
+
 	// 创建路由器
 	router := mux.NewRouter()
 
-	// 应用认证中间件
+	// 应用认证中间件与幂等中间件
 	router.Use(middleware.AuthMiddleware)
+	router.Use(middleware.IdempotencyMiddleware)
 
 	// 注册API路由
 	router.HandleFunc("/api/check", handlers.CheckServerHandler).Methods("GET")
+	router.HandleFunc("/api/clients", handlers.CreateClientHandler).Methods("POST")
 	router.HandleFunc("/api/accounts", handlers.GetAllAccountsHandler).Methods("GET")
 	router.HandleFunc("/api/account/create", handlers.CreateAccountHandler).Methods("POST")
 	router.HandleFunc("/api/account/deposit", handlers.DepositHandler).Methods("POST")
@@ -47,7 +67,13 @@ func main() {
 	router.HandleFunc("/api/account/transfer", handlers.TransferHandler).Methods("POST")
 	router.HandleFunc("/api/account/sync", handlers.SyncAccountHandler).Methods("POST")
 	router.HandleFunc("/api/account/{uuid}", handlers.DeleteAccountHandler).Methods("DELETE")
+	router.HandleFunc("/api/account/{uuid}/history", handlers.GetAccountHistoryHandler).Methods("GET")
+	router.HandleFunc("/api/tx/{id}", handlers.GetTransactionHandler).Methods("GET")
 	router.HandleFunc("/api/public_key", handlers.GetPublicKeyHandler).Methods("GET")
+	router.HandleFunc("/api/credit/register", handlers.RegisterCreditAccountHandler).Methods("POST")
+	router.HandleFunc("/api/credit/{uuid}/settle", handlers.GenerateStatementHandler).Methods("POST")
+	router.HandleFunc("/api/credit/{uuid}/statements", handlers.ListStatementsHandler).Methods("GET")
+	router.HandleFunc("/api/credit/statement/pay", handlers.PayStatementHandler).Methods("POST")
 
 	// 服务器配置
 	serverAddr := fmt.Sprintf(":%d", config.GlobalConfig.Server.Port)
@@ -91,3 +117,25 @@ func main() {
 	log.Println("再见！")
 }
 
+// runCreditStatementScheduler 定期检查信用账户，结算到期的账单周期
+func runCreditStatementScheduler() {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		uuids, err := models.ListCreditAccountUUIDs()
+		if err != nil {
+			log.Printf("查询信用账户列表失败: %v", err)
+			continue
+		}
+
+		for _, uuid := range uuids {
+			if _, err := models.GenerateStatement(context.Background(), uuid, false); err != nil {
+				if !strings.Contains(err.Error(), "尚未到期") {
+					log.Printf("信用账户 %s 账单结算失败: %v", uuid, err)
+				}
+			}
+		}
+	}
+}
+
@@ -1,87 +1,179 @@
-package middleware
-
-import (
-	"encoding/json"
-	"log"
-	"math"
-	"net/http"
-	"regexp"
-	"strconv"
-	"time"
-)
-
-// ErrorResponse 错误响应
-type ErrorResponse struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error"`
-}
-
-// AuthMiddleware 认证中间件
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 跳过 /api/check 端点的认证
-		if r.URL.Path == "/api/check" {
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		// 验证 Content-Type（仅对POST/PUT/PATCH等需要body的请求）
-		if r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH" {
-			contentType := r.Header.Get("Content-Type")
-			if contentType != "application/json" {
-				sendError(w, "Content-Type必须为application/json", http.StatusBadRequest)
-				return
-			}
-		}
-
-		// 验证 X-Client-Key
-		clientKey := r.Header.Get("X-Client-Key")
-		if clientKey == "" {
-			sendError(w, "缺少X-Client-Key请求头", http.StatusUnauthorized)
-			return
-		}
-
-		// 验证 X-Client-Key 格式（SHA256哈希，64字符十六进制）
-		matched, _ := regexp.MatchString("^[a-f0-9]{64}$", clientKey)
-		if !matched {
-			sendError(w, "X-Client-Key格式错误", http.StatusUnauthorized)
-			return
-		}
-
-		// 验证 X-Request-Time
-		requestTimeStr := r.Header.Get("X-Request-Time")
-		if requestTimeStr == "" {
-			sendError(w, "缺少X-Request-Time请求头", http.StatusUnauthorized)
-			return
-		}
-
-		requestTime, err := strconv.ParseInt(requestTimeStr, 10, 64)
-		if err != nil {
-			sendError(w, "X-Request-Time格式错误", http.StatusBadRequest)
-			return
-		}
-
-		// 验证时间戳（允许±5分钟误差）
-		currentTime := time.Now().Unix()
-		timeDiff := math.Abs(float64(currentTime - requestTime))
-		if timeDiff > 300 { // 5分钟 = 300秒
-			log.Printf("时间戳验证失败: 当前时间=%d, 请求时间=%d, 差值=%.0f秒", currentTime, requestTime, timeDiff)
-			sendError(w, "请求时间戳无效或已过期", http.StatusUnauthorized)
-			return
-		}
-
-		// 认证通过，继续处理
-		next.ServeHTTP(w, r)
-	})
-}
-
-// sendError 发送错误响应
-func sendError(w http.ResponseWriter, message string, statusCode int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(ErrorResponse{
-		Success: false,
-		Error:   message,
-	})
-}
-
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"bamsystem-backend/models"
+)
+
+// ErrorResponse 错误响应
+type ErrorResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+}
+
+// nonceTTL 签名nonce的重放检测窗口
+const nonceTTL = 10 * time.Minute
+
+// nonceCache 基于sync.Map的(client_id, nonce)去重缓存，后台goroutine定期清理过期条目
+type nonceCache struct {
+	entries sync.Map // key -> 过期时间 time.Time
+}
+
+var replayCache = newNonceCache()
+
+func newNonceCache() *nonceCache {
+	c := &nonceCache{}
+	go c.sweep()
+	return c
+}
+
+func (c *nonceCache) sweep() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		c.entries.Range(func(key, value interface{}) bool {
+			if expiry, ok := value.(time.Time); ok && now.After(expiry) {
+				c.entries.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// seenRecently 若key在TTL窗口内已出现过则返回true（判定为重放），否则记录该key并返回false
+func (c *nonceCache) seenRecently(key string) bool {
+	now := time.Now()
+	v, loaded := c.entries.LoadOrStore(key, now.Add(nonceTTL))
+	if !loaded {
+		return false
+	}
+	if expiry, ok := v.(time.Time); ok && now.Before(expiry) {
+		return true
+	}
+	// 条目已过期但尚未被sweeper清理，视为新请求并刷新过期时间
+	c.entries.Store(key, now.Add(nonceTTL))
+	return false
+}
+
+// AuthMiddleware 认证中间件：校验HMAC请求签名并防止重放
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 跳过 /api/check 端点的认证；/api/clients 用于注册调用方本身，
+		// 此时客户端尚无共享密钥可签名，改由该端点自行校验管理员令牌
+		if r.URL.Path == "/api/check" || r.URL.Path == "/api/clients" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 验证 Content-Type（仅对POST/PUT/PATCH等需要body的请求）
+		if r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH" {
+			contentType := r.Header.Get("Content-Type")
+			if contentType != "application/json" {
+				sendError(w, "Content-Type必须为application/json", http.StatusBadRequest)
+				return
+			}
+		}
+
+		clientID := r.Header.Get("X-Client-Id")
+		requestTimeStr := r.Header.Get("X-Request-Time")
+		nonce := r.Header.Get("X-Nonce")
+		signature := r.Header.Get("X-Signature")
+
+		if clientID == "" || requestTimeStr == "" || nonce == "" || signature == "" {
+			sendError(w, "缺少认证请求头", http.StatusUnauthorized)
+			return
+		}
+
+		requestTime, err := strconv.ParseInt(requestTimeStr, 10, 64)
+		if err != nil {
+			sendError(w, "X-Request-Time格式错误", http.StatusBadRequest)
+			return
+		}
+
+		// 验证时间戳（允许±5分钟误差）
+		currentTime := time.Now().Unix()
+		timeDiff := math.Abs(float64(currentTime - requestTime))
+		if timeDiff > 300 { // 5分钟 = 300秒
+			log.Printf("时间戳验证失败: 当前时间=%d, 请求时间=%d, 差值=%.0f秒", currentTime, requestTime, timeDiff)
+			sendError(w, "请求时间戳无效或已过期", http.StatusUnauthorized)
+			return
+		}
+
+		// 读取请求体用于签名校验，并恢复给后续handler使用
+		var bodyBytes []byte
+		if r.Body != nil {
+			bodyBytes, err = io.ReadAll(r.Body)
+			if err != nil {
+				sendError(w, "读取请求体失败", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		}
+
+		secretHash, err := models.GetClientSecretHash(clientID)
+		if err != nil {
+			sendError(w, "客户端未注册", http.StatusUnauthorized)
+			return
+		}
+
+		expectedSignature := computeSignature(secretHash, r.Method, r.URL.Path, requestTimeStr, nonce, bodyBytes)
+		signatureBytes, err := hex.DecodeString(signature)
+		if err != nil || !hmac.Equal(signatureBytes, expectedSignature) {
+			sendError(w, "签名校验失败", http.StatusUnauthorized)
+			return
+		}
+
+		// 防重放：同一(client_id, nonce)在有效期内只能被使用一次
+		if replayCache.seenRecently(clientID + "|" + nonce) {
+			sendError(w, "请求已被重放拦截", http.StatusUnauthorized)
+			return
+		}
+
+		// 认证通过，继续处理
+		next.ServeHTTP(w, r)
+	})
+}
+
+// computeSignature 按 method\npath\ntimestamp\nnonce\nsha256(body) 拼接消息计算HMAC-SHA256
+// secretHash 为 api_clients.secret_hash 中存储的hex编码值，hex解码后的字节作为HMAC密钥使用
+func computeSignature(secretHash, method, path, timestamp, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	message := fmt.Sprintf("%s\n%s\n%s\n%s\n%s", method, path, timestamp, nonce, hex.EncodeToString(bodyHash[:]))
+
+	key, _ := hex.DecodeString(secretHash)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// SignRequest 供客户端代码/测试生成合法签名；secret为注册时返回的原始共享密钥
+func SignRequest(secret, method, path string, timestamp int64, nonce string, body []byte) string {
+	hash := sha256.Sum256([]byte(secret))
+	secretHash := hex.EncodeToString(hash[:])
+	signature := computeSignature(secretHash, method, path, strconv.FormatInt(timestamp, 10), nonce, body)
+	return hex.EncodeToString(signature)
+}
+
+// sendError 发送错误响应
+func sendError(w http.ResponseWriter, message string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Success: false,
+		Error:   message,
+	})
+}
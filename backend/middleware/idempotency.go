@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"bamsystem-backend/models"
+)
+
+// idempotencyTTL 幂等记录完成后的有效期
+const idempotencyTTL = 24 * time.Hour
+
+// idempotencyPendingTTL 占位记录的初始有效期：需要盖过一次正常写操作的最长耗时，
+// 否则一个仍在处理中但较慢的请求会被另一个并发重试错误地判定为"已崩溃"而重新抢占、重复执行handler；
+// 同时又要远小于idempotencyTTL，使处理方异常退出未能写回真实响应时占用不会永久锁死该key
+const idempotencyPendingTTL = 2 * time.Minute
+
+// idempotencyWaitTimeout 等待同一(client_id, key)的在途请求完成的最长时间
+const idempotencyWaitTimeout = 10 * time.Second
+
+// idempotencyPollInterval 轮询在途请求完成状态的间隔
+const idempotencyPollInterval = 100 * time.Millisecond
+
+// idempotencyKeyPattern 接受UUID或32位以上的token作为Idempotency-Key
+var idempotencyKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{32,}$`)
+
+func init() {
+	go sweepIdempotencyKeys()
+}
+
+// sweepIdempotencyKeys 后台定期清理过期的幂等记录
+func sweepIdempotencyKeys() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		if n, err := models.PurgeExpiredIdempotencyKeys(); err != nil {
+			log.Printf("清理幂等记录失败: %v", err)
+		} else if n > 0 {
+			log.Printf("清理过期幂等记录 %d 条", n)
+		}
+	}
+}
+
+// isMutatingMethod 判断该方法是否需要幂等保护
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// responseRecorder 捕获handler写出的状态码与响应体，供落库重放使用
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// IdempotencyMiddleware 对写操作提供幂等保护：以(client_id, key)原子占位，
+// 第一个到达的请求执行handler并写回真实响应，并发到达的同key请求等待其完成后直接重放，
+// 请求不一致时返回409；未携带该请求头时放行并记录警告，以便运营方后续强制要求携带
+func IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isMutatingMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// /api/clients 在AuthMiddleware中被豁免签名校验，此时X-Client-Id不可信，
+		// 若仍纳入幂等保护会与真实管理员共享同一(client_id="", key)占位记录
+		if r.URL.Path == "/api/clients" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			log.Printf("警告: %s %s 未携带Idempotency-Key，跳过幂等保护", r.Method, r.URL.Path)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !idempotencyKeyPattern.MatchString(key) {
+			sendError(w, "Idempotency-Key格式错误", http.StatusBadRequest)
+			return
+		}
+
+		var bodyBytes []byte
+		if r.Body != nil {
+			var err error
+			bodyBytes, err = io.ReadAll(r.Body)
+			if err != nil {
+				sendError(w, "读取请求体失败", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		}
+
+		clientID := r.Header.Get("X-Client-Id")
+		requestHash := hashIdempotentRequest(r.Method, r.URL.Path, bodyBytes)
+
+		// ReserveIdempotencyKey本身会接管物理上仍在但已过期的占位记录，
+		// 这里最多重试一次是为了覆盖"等待期间记录才过期"的极窄窗口，而不是依赖未加保护地直接放行
+		for attempt := 0; attempt < 2; attempt++ {
+			reserved, err := models.ReserveIdempotencyKey(clientID, key, requestHash, idempotencyPendingTTL)
+			if err != nil {
+				log.Printf("占用幂等记录失败: %v", err)
+				sendError(w, "幂等校验失败", http.StatusInternalServerError)
+				return
+			}
+
+			if reserved {
+				completed := false
+				defer func() {
+					if !completed {
+						if err := models.ReleaseIdempotencyKey(clientID, key); err != nil {
+							log.Printf("释放幂等记录失败: %v", err)
+						}
+					}
+				}()
+
+				recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+				next.ServeHTTP(recorder, r)
+
+				if err := models.CompleteIdempotencyRecord(clientID, key, recorder.status, recorder.body.Bytes(), idempotencyTTL); err != nil {
+					log.Printf("保存幂等记录失败: %v", err)
+					return
+				}
+				completed = true
+				return
+			}
+
+			record, err := waitForIdempotencyRecord(clientID, key)
+			if err != nil {
+				log.Printf("查询幂等记录失败: %v", err)
+				sendError(w, "幂等校验失败", http.StatusInternalServerError)
+				return
+			}
+
+			if record == nil {
+				// 等待期间该占位记录刚好过期：重新尝试占用，而不是不加保护地直接执行handler
+				continue
+			}
+
+			if record.IsPending() {
+				sendError(w, "相同Idempotency-Key的请求正在处理中，请稍后重试", http.StatusConflict)
+				return
+			}
+
+			if record.RequestHash != requestHash {
+				sendError(w, "Idempotency-Key已被用于不同的请求", http.StatusConflict)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(record.ResponseStatus)
+			w.Write(record.ResponseBody)
+			return
+		}
+
+		// 两次尝试都未能占用到key也等不到有效记录，保守拒绝以避免并发无保护执行handler
+		sendError(w, "幂等校验失败，请重试", http.StatusServiceUnavailable)
+	})
+}
+
+// waitForIdempotencyRecord 轮询等待占用该(client_id, key)的在途请求完成，避免并发重试重复执行handler
+func waitForIdempotencyRecord(clientID, key string) (*models.IdempotencyRecord, error) {
+	deadline := time.Now().Add(idempotencyWaitTimeout)
+	for {
+		record, err := models.GetIdempotencyRecord(clientID, key)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil || !record.IsPending() {
+			return record, nil
+		}
+		if time.Now().After(deadline) {
+			return record, nil
+		}
+		time.Sleep(idempotencyPollInterval)
+	}
+}
+
+// hashIdempotentRequest 对请求方法、路径与请求体计算哈希，用于判断同一Idempotency-Key是否被复用于不同请求
+func hashIdempotentRequest(method, path string, body []byte) string {
+	hash := sha256.Sum256(append([]byte(method+"\n"+path+"\n"), body...))
+	return hex.EncodeToString(hash[:])
+}
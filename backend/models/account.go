@@ -1,28 +1,64 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"math/big"
 	"time"
 
 	"bamsystem-backend/database"
 )
 
+// DefaultCurrency 未显式指定币种时使用的默认币种
+const DefaultCurrency = "CNY"
+
 // Account 账户模型
+// Balance 为有符号整数：信用账户允许透支，余额可能为负
 type Account struct {
 	UUID      string    `json:"uuid"`
-	Balance   uint64    `json:"balance"`
+	Balance   int64     `json:"balance"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// CreateAccount 创建账户
-func CreateAccount(uuid string, balance uint64) error {
-	query := "INSERT INTO accounts (uuid, balance) VALUES (?, ?)"
-	_, err := database.DB.Exec(query, uuid, balance)
+// CreateAccount 创建账户，balance 以 currency 的最小货币单位计
+func CreateAccount(uuid string, balance uint64, currency string) error {
+	if !IsCurrencyAllowed(currency) {
+		return fmt.Errorf("不支持的币种: %s", currency)
+	}
+
+	tx, err := database.DB.Begin()
 	if err != nil {
+		return fmt.Errorf("开始事务失败: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// accounts.balance 仅作为默认币种的历史遗留展示字段，真实余额以 account_balances 为准
+	legacyBalance := int64(0)
+	if currency == DefaultCurrency {
+		legacyBalance = int64(balance)
+	}
+
+	if _, err = tx.Exec("INSERT INTO accounts (uuid, balance) VALUES (?, ?)", uuid, legacyBalance); err != nil {
 		return fmt.Errorf("创建账户失败: %v", err)
 	}
+
+	if _, err = tx.Exec(
+		"INSERT INTO account_balances (uuid, currency, balance) VALUES (?, ?, ?)",
+		uuid, currency, balance,
+	); err != nil {
+		return fmt.Errorf("创建账户币种余额失败: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("提交事务失败: %v", err)
+	}
+
 	return nil
 }
 
@@ -44,7 +80,7 @@ func GetAccount(uuid string) (*Account, error) {
 }
 
 // UpdateBalance 更新账户余额
-func UpdateBalance(uuid string, newBalance uint64) error {
+func UpdateBalance(uuid string, newBalance int64) error {
 	query := "UPDATE accounts SET balance = ? WHERE uuid = ?"
 	result, err := database.DB.Exec(query, newBalance, uuid)
 	if err != nil {
@@ -63,97 +99,183 @@ func UpdateBalance(uuid string, newBalance uint64) error {
 	return nil
 }
 
-// Deposit 存款
-func Deposit(uuid string, amount uint64) (uint64, error) {
-	account, err := GetAccount(uuid)
+// GetAccountBalance 查询账户在指定币种下的余额（有符号，信用账户可能为负）
+func GetAccountBalance(uuid, currency string) (int64, error) {
+	var balance int64
+	err := database.DB.QueryRow(
+		"SELECT balance FROM account_balances WHERE uuid = ? AND currency = ?",
+		uuid, currency,
+	).Scan(&balance)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			if !AccountExists(uuid) {
+				return 0, fmt.Errorf("账户不存在")
+			}
+			return 0, nil
+		}
+		return 0, fmt.Errorf("查询账户余额失败: %v", err)
+	}
+	return balance, nil
+}
+
+// GetAccountBalances 查询账户名下所有币种的余额
+func GetAccountBalances(uuid string) (map[string]int64, error) {
+	rows, err := database.DB.Query("SELECT currency, balance FROM account_balances WHERE uuid = ?", uuid)
+	if err != nil {
+		return nil, fmt.Errorf("查询账户余额失败: %v", err)
+	}
+	defer rows.Close()
+
+	balances := make(map[string]int64)
+	for rows.Next() {
+		var currency string
+		var balance int64
+		if err := rows.Scan(&currency, &balance); err != nil {
+			return nil, fmt.Errorf("扫描余额数据失败: %v", err)
+		}
+		balances[currency] = balance
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("遍历余额数据失败: %v", err)
+	}
+
+	return balances, nil
+}
+
+// Deposit 存款，记为一笔“系统账户 -> 目标账户”的平衡交易
+func Deposit(uuid string, amount uint64, currency string) (int64, error) {
+	if !IsCurrencyAllowed(currency) {
+		return 0, fmt.Errorf("不支持的币种: %s", currency)
+	}
+	if err := ensureSystemAccount(); err != nil {
 		return 0, err
 	}
 
-	newBalance := account.Balance + amount
-	if err := UpdateBalance(uuid, newBalance); err != nil {
+	ledger := NewLedger()
+	postings := []Posting{
+		{AccountUUID: SystemAccountUUID, Direction: DirectionDebit, Amount: amount, Currency: currency},
+		{AccountUUID: uuid, Direction: DirectionCredit, Amount: amount, Currency: currency},
+	}
+	if _, err := ledger.PostTransaction(context.Background(), "deposit", "", "", postings); err != nil {
 		return 0, err
 	}
 
-	return newBalance, nil
+	return GetAccountBalance(uuid, currency)
 }
 
-// Withdraw 取款
-func Withdraw(uuid string, amount uint64) (uint64, error) {
-	account, err := GetAccount(uuid)
-	if err != nil {
-		return 0, err
+// Withdraw 取款，记为一笔“目标账户 -> 系统账户”的平衡交易
+// 信用账户在DefaultCurrency下允许余额透支至 -credit_limit（见checkOverdraft）
+func Withdraw(uuid string, amount uint64, currency string) (int64, error) {
+	if !IsCurrencyAllowed(currency) {
+		return 0, fmt.Errorf("不支持的币种: %s", currency)
 	}
 
-	if account.Balance < amount {
-		return 0, fmt.Errorf("余额不足")
+	if !AccountExists(uuid) {
+		return 0, fmt.Errorf("账户不存在")
 	}
 
-	newBalance := account.Balance - amount
-	if err := UpdateBalance(uuid, newBalance); err != nil {
+	if err := ensureSystemAccount(); err != nil {
+		return 0, err
+	}
+
+	ledger := NewLedger()
+	postings := []Posting{
+		{AccountUUID: uuid, Direction: DirectionDebit, Amount: amount, Currency: currency},
+		{AccountUUID: SystemAccountUUID, Direction: DirectionCredit, Amount: amount, Currency: currency},
+	}
+	if _, err := ledger.PostTransaction(context.Background(), "withdraw", "", "", postings); err != nil {
 		return 0, err
 	}
 
-	return newBalance, nil
+	return GetAccountBalance(uuid, currency)
 }
 
-// Transfer 转账（使用事务）
-func Transfer(uuidFrom, uuidTo string, amount uint64) error {
-	// 开始事务
-	tx, err := database.DB.Begin()
-	if err != nil {
-		return fmt.Errorf("开始事务失败: %v", err)
+// Transfer 转账，记为一笔“转出账户 -> 转入账户”的平衡交易
+// targetCurrency 非空且与 currency 不同时，按最新汇率换汇，并将转入金额的整数部分与取整尾差分开入账
+// 信用账户在DefaultCurrency下允许转出账户余额透支至 -credit_limit（见checkOverdraft）
+func Transfer(uuidFrom, uuidTo, currency, targetCurrency string, amount uint64) error {
+	if !IsCurrencyAllowed(currency) {
+		return fmt.Errorf("不支持的币种: %s", currency)
 	}
 
-	// 确保事务正确结束
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
+	if !AccountExists(uuidFrom) {
+		return fmt.Errorf("转出账户不存在")
+	}
+	if !AccountExists(uuidTo) {
+		return fmt.Errorf("转入账户不存在")
+	}
 
-	// 检查转出账户余额
-	var fromBalance uint64
-	err = tx.QueryRow("SELECT balance FROM accounts WHERE uuid = ?", uuidFrom).Scan(&fromBalance)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("转出账户不存在")
+	destCurrency := currency
+	if targetCurrency != "" {
+		destCurrency = targetCurrency
+	}
+	if !IsCurrencyAllowed(destCurrency) {
+		return fmt.Errorf("不支持的币种: %s", destCurrency)
+	}
+
+	ledger := NewLedger()
+
+	if destCurrency == currency {
+		postings := []Posting{
+			{AccountUUID: uuidFrom, Direction: DirectionDebit, Amount: amount, Currency: currency},
+			{AccountUUID: uuidTo, Direction: DirectionCredit, Amount: amount, Currency: currency},
 		}
-		return fmt.Errorf("查询转出账户失败: %v", err)
+		_, err := ledger.PostTransaction(context.Background(), "transfer", "", "", postings)
+		return err
 	}
 
-	if fromBalance < amount {
-		return fmt.Errorf("转出账户余额不足")
+	if err := ensureSystemAccount(); err != nil {
+		return err
 	}
 
-	// 检查转入账户是否存在
-	var toBalance uint64
-	err = tx.QueryRow("SELECT balance FROM accounts WHERE uuid = ?", uuidTo).Scan(&toBalance)
+	rate, err := GetLatestRate(context.Background(), currency, destCurrency)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("转入账户不存在")
-		}
-		return fmt.Errorf("查询转入账户失败: %v", err)
+		return fmt.Errorf("获取汇率失败: %v", err)
 	}
 
-	// 扣除转出账户余额
-	_, err = tx.Exec("UPDATE accounts SET balance = balance - ? WHERE uuid = ?", amount, uuidFrom)
-	if err != nil {
-		return fmt.Errorf("扣除转出账户余额失败: %v", err)
+	// 换汇金额必须是可复算、可审计的确定值：float64乘法对于不同输入可能产生不同的舍入误差，
+	// 这里将rate还原为精确分数后用big.Int做整数运算，结果与平台/编译器无关
+	rateRat := new(big.Rat).SetFloat64(rate)
+	if rateRat == nil {
+		return fmt.Errorf("汇率无效: %v", rate)
 	}
+	exact := new(big.Rat).Mul(new(big.Rat).SetUint64(amount), rateRat)
+	num, den := exact.Num(), exact.Denom()
 
-	// 增加转入账户余额
-	_, err = tx.Exec("UPDATE accounts SET balance = balance + ? WHERE uuid = ?", amount, uuidTo)
-	if err != nil {
-		return fmt.Errorf("增加转入账户余额失败: %v", err)
+	// num、den 均为非负数，Quo即为向下取整
+	convertedInt := new(big.Int).Quo(num, den)
+	fracNum := new(big.Int).Sub(num, new(big.Int).Mul(convertedInt, den))
+
+	// 尾差按四舍五入取整：分数部分 fracNum/den >= 1/2 时进一
+	remainderInt := big.NewInt(0)
+	if new(big.Int).Lsh(fracNum, 1).Cmp(den) >= 0 {
+		remainderInt.SetInt64(1)
 	}
 
-	// 提交事务
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("提交事务失败: %v", err)
+	if !convertedInt.IsUint64() {
+		return fmt.Errorf("换汇金额超出可表示范围")
+	}
+	converted := convertedInt.Uint64()
+	remainder := remainderInt.Uint64()
+
+	postings := []Posting{
+		// 来源币种：转出账户 -> 系统账户，按原币种全额借记
+		{AccountUUID: uuidFrom, Direction: DirectionDebit, Amount: amount, Currency: currency},
+		{AccountUUID: SystemAccountUUID, Direction: DirectionCredit, Amount: amount, Currency: currency},
+		// 目标币种：系统账户 -> 转入账户，按汇率换算后的整数部分
+		{AccountUUID: SystemAccountUUID, Direction: DirectionDebit, Amount: converted, Currency: destCurrency},
+		{AccountUUID: uuidTo, Direction: DirectionCredit, Amount: converted, Currency: destCurrency},
+	}
+	if remainder > 0 {
+		// 换汇取整产生的尾差单独入账，便于对账时追溯
+		postings = append(postings,
+			Posting{AccountUUID: SystemAccountUUID, Direction: DirectionDebit, Amount: remainder, Currency: destCurrency},
+			Posting{AccountUUID: uuidTo, Direction: DirectionCredit, Amount: remainder, Currency: destCurrency},
+		)
 	}
 
-	return nil
+	_, err = ledger.PostTransaction(context.Background(), "transfer_fx", fmt.Sprintf("rate=%.8f", rate), "", postings)
+	return err
 }
 
 // DeleteAccount 删除账户
@@ -176,18 +298,50 @@ func DeleteAccount(uuid string) error {
 	return nil
 }
 
-// SyncAccount 同步账户数据（创建或更新）
-func SyncAccount(uuid string, balance uint64) error {
-	query := `
-		INSERT INTO accounts (uuid, balance) 
-		VALUES (?, ?) 
-		ON DUPLICATE KEY UPDATE balance = ?
-	`
-	_, err := database.DB.Exec(query, uuid, balance, balance)
+// SyncAccount 将账户在指定币种下的余额对齐到目标值
+// 与其直接覆写 accounts.balance，这里记一笔“系统账户 <-> 目标账户”的调整交易走账本，
+// 保持与 account_balances/postings 的一致性，不绕过复式记账审计
+func SyncAccount(uuid string, balance int64, currency string) (int64, error) {
+	if !AccountExists(uuid) {
+		return 0, fmt.Errorf("账户不存在")
+	}
+	if !IsCurrencyAllowed(currency) {
+		return 0, fmt.Errorf("不支持的币种: %s", currency)
+	}
+
+	current, err := GetAccountBalance(uuid, currency)
 	if err != nil {
-		return fmt.Errorf("同步账户失败: %v", err)
+		return 0, err
 	}
-	return nil
+
+	delta := balance - current
+	if delta == 0 {
+		return current, nil
+	}
+
+	if err := ensureSystemAccount(); err != nil {
+		return 0, err
+	}
+
+	var postings []Posting
+	if delta > 0 {
+		postings = []Posting{
+			{AccountUUID: SystemAccountUUID, Direction: DirectionDebit, Amount: uint64(delta), Currency: currency},
+			{AccountUUID: uuid, Direction: DirectionCredit, Amount: uint64(delta), Currency: currency},
+		}
+	} else {
+		postings = []Posting{
+			{AccountUUID: uuid, Direction: DirectionDebit, Amount: uint64(-delta), Currency: currency},
+			{AccountUUID: SystemAccountUUID, Direction: DirectionCredit, Amount: uint64(-delta), Currency: currency},
+		}
+	}
+
+	ledger := NewLedger()
+	if _, err := ledger.PostTransaction(context.Background(), "sync_adjustment", "", "", postings); err != nil {
+		return 0, err
+	}
+
+	return GetAccountBalance(uuid, currency)
 }
 
 // AccountExists 检查账户是否存在
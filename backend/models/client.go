@@ -0,0 +1,45 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+
+	"bamsystem-backend/database"
+)
+
+// CreateAPIClient 注册一个新的调用方，返回仅在创建时可见一次的共享密钥
+// 数据库中只落盘该密钥的SHA256哈希，供 middleware 校验请求签名使用
+func CreateAPIClient(clientID string) (string, error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", fmt.Errorf("生成密钥失败: %v", err)
+	}
+	secret := hex.EncodeToString(secretBytes)
+
+	hash := sha256.Sum256([]byte(secret))
+	_, err := database.DB.Exec(
+		"INSERT INTO api_clients (client_id, secret_hash) VALUES (?, ?)",
+		clientID, hex.EncodeToString(hash[:]),
+	)
+	if err != nil {
+		return "", fmt.Errorf("注册客户端失败: %v", err)
+	}
+
+	return secret, nil
+}
+
+// GetClientSecretHash 查询客户端密钥的哈希值，用于校验请求签名
+func GetClientSecretHash(clientID string) (string, error) {
+	var hash string
+	err := database.DB.QueryRow("SELECT secret_hash FROM api_clients WHERE client_id = ?", clientID).Scan(&hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("客户端不存在")
+		}
+		return "", fmt.Errorf("查询客户端失败: %v", err)
+	}
+	return hash, nil
+}
@@ -0,0 +1,364 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"bamsystem-backend/database"
+)
+
+// BillingCycle 信用账户的账单周期
+type BillingCycle string
+
+const (
+	BillingCycleWeekly  BillingCycle = "weekly"
+	BillingCycleMonthly BillingCycle = "monthly"
+)
+
+// StatementStatus 账单状态
+type StatementStatus string
+
+const (
+	StatementStatusOpen   StatementStatus = "open"
+	StatementStatusClosed StatementStatus = "closed"
+	StatementStatusPaid   StatementStatus = "paid"
+)
+
+// CreditAccount 信用（后付费）账户的配置
+type CreditAccount struct {
+	UUID         string       `json:"uuid"`
+	CreditLimit  uint64       `json:"credit_limit"`
+	BillingCycle BillingCycle `json:"billing_cycle"`
+	CycleAnchor  time.Time    `json:"cycle_anchor"`
+	GraceDays    int          `json:"grace_days"`
+}
+
+// CreditStatement 一个账单周期的结算快照
+type CreditStatement struct {
+	ID             int64           `json:"id"`
+	UUID           string          `json:"uuid"`
+	PeriodStart    time.Time       `json:"period_start"`
+	PeriodEnd      time.Time       `json:"period_end"`
+	OpeningBalance int64           `json:"opening_balance"`
+	Charges        uint64          `json:"charges"`
+	Payments       uint64          `json:"payments"`
+	ClosingBalance int64           `json:"closing_balance"`
+	DueDate        sql.NullTime    `json:"due_date"`
+	Status         StatementStatus `json:"status"`
+}
+
+// RegisterCreditAccount 将一个已存在的账户开通为信用账户，并开启首个账单周期
+func RegisterCreditAccount(uuid string, creditLimit uint64, cycle BillingCycle, anchor time.Time, graceDays int) error {
+	if cycle != BillingCycleWeekly && cycle != BillingCycleMonthly {
+		return fmt.Errorf("不支持的账单周期: %s", cycle)
+	}
+	if !AccountExists(uuid) {
+		return fmt.Errorf("账户不存在")
+	}
+
+	opening, err := GetAccountBalance(uuid, DefaultCurrency)
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("开始事务失败: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(
+		"INSERT INTO credit_accounts (uuid, credit_limit, billing_cycle, cycle_anchor, grace_days) VALUES (?, ?, ?, ?, ?)",
+		uuid, creditLimit, cycle, anchor, graceDays,
+	); err != nil {
+		return fmt.Errorf("开通信用账户失败: %v", err)
+	}
+
+	periodEnd := nextCycleBoundary(anchor, cycle)
+	if _, err = tx.Exec(
+		`INSERT INTO credit_statements (uuid, period_start, period_end, opening_balance, charges, payments, closing_balance, status)
+		 VALUES (?, ?, ?, ?, 0, 0, ?, ?)`,
+		uuid, anchor, periodEnd, opening, opening, StatementStatusOpen,
+	); err != nil {
+		return fmt.Errorf("创建账单周期失败: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("提交事务失败: %v", err)
+	}
+
+	return nil
+}
+
+// IsCreditAccount 判断账户是否已开通为信用账户
+func IsCreditAccount(uuid string) bool {
+	var exists bool
+	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM credit_accounts WHERE uuid = ?)", uuid).Scan(&exists)
+	return err == nil && exists
+}
+
+// GetCreditAccount 查询信用账户配置
+func GetCreditAccount(uuid string) (*CreditAccount, error) {
+	row := database.DB.QueryRow(
+		"SELECT uuid, credit_limit, billing_cycle, cycle_anchor, grace_days FROM credit_accounts WHERE uuid = ?",
+		uuid,
+	)
+
+	acc := &CreditAccount{}
+	if err := row.Scan(&acc.UUID, &acc.CreditLimit, &acc.BillingCycle, &acc.CycleAnchor, &acc.GraceDays); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("信用账户不存在")
+		}
+		return nil, fmt.Errorf("查询信用账户失败: %v", err)
+	}
+
+	return acc, nil
+}
+
+// ListCreditAccountUUIDs 列出全部已开通信用账户的UUID，供调度器遍历结算
+func ListCreditAccountUUIDs() ([]string, error) {
+	rows, err := database.DB.Query("SELECT uuid FROM credit_accounts")
+	if err != nil {
+		return nil, fmt.Errorf("查询信用账户列表失败: %v", err)
+	}
+	defer rows.Close()
+
+	var uuids []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, fmt.Errorf("扫描信用账户数据失败: %v", err)
+		}
+		uuids = append(uuids, uuid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("遍历信用账户数据失败: %v", err)
+	}
+
+	return uuids, nil
+}
+
+// checkOverdraft 校验取款/转出会否导致余额不足；信用账户仅在DefaultCurrency下允许透支至 -credit_limit，
+// 其余币种余额不得为负
+func checkOverdraft(uuid, currency string, balance int64, amount uint64) error {
+	projected := balance - int64(amount)
+	if projected >= 0 {
+		return nil
+	}
+	if currency != DefaultCurrency {
+		return fmt.Errorf("余额不足")
+	}
+
+	creditAccount, err := GetCreditAccount(uuid)
+	if err != nil {
+		return fmt.Errorf("余额不足")
+	}
+	if projected < -int64(creditAccount.CreditLimit) {
+		return fmt.Errorf("超出信用额度")
+	}
+
+	return nil
+}
+
+// nextCycleBoundary 根据账单周期计算下一个周期边界
+func nextCycleBoundary(from time.Time, cycle BillingCycle) time.Time {
+	if cycle == BillingCycleWeekly {
+		return from.AddDate(0, 0, 7)
+	}
+	return from.AddDate(0, 1, 0)
+}
+
+// getOpenStatement 查询账户当前未结算的账单周期
+func getOpenStatement(uuid string) (*CreditStatement, error) {
+	row := database.DB.QueryRow(
+		`SELECT id, uuid, period_start, period_end, opening_balance, charges, payments, closing_balance, due_date, status
+		 FROM credit_statements WHERE uuid = ? AND status = ? ORDER BY id DESC LIMIT 1`,
+		uuid, StatementStatusOpen,
+	)
+
+	s := &CreditStatement{}
+	if err := row.Scan(
+		&s.ID, &s.UUID, &s.PeriodStart, &s.PeriodEnd, &s.OpeningBalance,
+		&s.Charges, &s.Payments, &s.ClosingBalance, &s.DueDate, &s.Status,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("不存在未结算的账单周期")
+		}
+		return nil, fmt.Errorf("查询账单周期失败: %v", err)
+	}
+
+	return s, nil
+}
+
+// GenerateStatement 结算账户当前的账单周期：汇总周期内的消费与还款，关闭当前账单并开启下一周期
+// force为true时忽略周期是否到达边界，直接按当前时间结算（供手动触发使用）
+func GenerateStatement(ctx context.Context, uuid string, force bool) (*CreditStatement, error) {
+	creditAccount, err := GetCreditAccount(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	open, err := getOpenStatement(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	if !force && time.Now().Before(open.PeriodEnd) {
+		return nil, fmt.Errorf("账单周期尚未到期")
+	}
+
+	// 汇总周期内该账户的全部分录：借记视为消费(charges)，贷记视为还款(payments)
+	// 信用额度与账单结算均只针对DefaultCurrency（见checkOverdraft），按currency过滤避免跨币种金额被直接相加
+	rows, err := database.DB.QueryContext(ctx,
+		"SELECT direction, amount FROM postings WHERE account_uuid = ? AND currency = ? AND created_at >= ? AND created_at < ?",
+		uuid, DefaultCurrency, open.PeriodStart, open.PeriodEnd,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("查询周期流水失败: %v", err)
+	}
+
+	var charges, payments uint64
+	for rows.Next() {
+		var direction Direction
+		var amount uint64
+		if err := rows.Scan(&direction, &amount); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("扫描流水数据失败: %v", err)
+		}
+		if direction == DirectionDebit {
+			charges += amount
+		} else {
+			payments += amount
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("遍历流水数据失败: %v", err)
+	}
+	rows.Close()
+
+	closingBalance := open.OpeningBalance - int64(charges) + int64(payments)
+	dueDate := open.PeriodEnd.AddDate(0, 0, creditAccount.GraceDays)
+
+	tx, err := database.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("开始事务失败: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx,
+		"UPDATE credit_statements SET charges = ?, payments = ?, closing_balance = ?, due_date = ?, status = ? WHERE id = ?",
+		charges, payments, closingBalance, dueDate, StatementStatusClosed, open.ID,
+	); err != nil {
+		return nil, fmt.Errorf("结算账单失败: %v", err)
+	}
+
+	nextStart := open.PeriodEnd
+	nextEnd := nextCycleBoundary(nextStart, creditAccount.BillingCycle)
+	if _, err = tx.ExecContext(ctx,
+		`INSERT INTO credit_statements (uuid, period_start, period_end, opening_balance, charges, payments, closing_balance, status)
+		 VALUES (?, ?, ?, ?, 0, 0, ?, ?)`,
+		uuid, nextStart, nextEnd, closingBalance, closingBalance, StatementStatusOpen,
+	); err != nil {
+		return nil, fmt.Errorf("创建下一账单周期失败: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("提交事务失败: %v", err)
+	}
+
+	open.Charges = charges
+	open.Payments = payments
+	open.ClosingBalance = closingBalance
+	open.Status = StatementStatusClosed
+	return open, nil
+}
+
+// ListStatements 查询账户的账单列表（含当前未结算周期），按时间倒序
+func ListStatements(uuid string) ([]CreditStatement, error) {
+	rows, err := database.DB.Query(
+		`SELECT id, uuid, period_start, period_end, opening_balance, charges, payments, closing_balance, due_date, status
+		 FROM credit_statements WHERE uuid = ? ORDER BY id DESC`,
+		uuid,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("查询账单列表失败: %v", err)
+	}
+	defer rows.Close()
+
+	var statements []CreditStatement
+	for rows.Next() {
+		var s CreditStatement
+		if err := rows.Scan(
+			&s.ID, &s.UUID, &s.PeriodStart, &s.PeriodEnd, &s.OpeningBalance,
+			&s.Charges, &s.Payments, &s.ClosingBalance, &s.DueDate, &s.Status,
+		); err != nil {
+			return nil, fmt.Errorf("扫描账单数据失败: %v", err)
+		}
+		statements = append(statements, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("遍历账单数据失败: %v", err)
+	}
+
+	return statements, nil
+}
+
+// PayStatement 对一笔已关闭的账单还款，记为一笔带账单标记的交易；充分覆盖欠款后账单标记为已结清
+func PayStatement(ctx context.Context, statementID int64, amount uint64) error {
+	row := database.DB.QueryRow("SELECT uuid, charges, payments, status FROM credit_statements WHERE id = ?", statementID)
+
+	var uuid string
+	var charges, payments uint64
+	var status StatementStatus
+	if err := row.Scan(&uuid, &charges, &payments, &status); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("账单不存在")
+		}
+		return fmt.Errorf("查询账单失败: %v", err)
+	}
+	if status == StatementStatusOpen {
+		return fmt.Errorf("账单尚未关闭，无法还款")
+	}
+	if status == StatementStatusPaid {
+		return fmt.Errorf("账单已结清")
+	}
+
+	if err := ensureSystemAccount(); err != nil {
+		return err
+	}
+
+	ledger := NewLedger()
+	postings := []Posting{
+		{AccountUUID: SystemAccountUUID, Direction: DirectionDebit, Amount: amount, Currency: DefaultCurrency},
+		{AccountUUID: uuid, Direction: DirectionCredit, Amount: amount, Currency: DefaultCurrency},
+	}
+	if _, err := ledger.PostTransaction(ctx, "credit_payment", fmt.Sprintf("statement:%d", statementID), "", postings); err != nil {
+		return err
+	}
+
+	newPayments := payments + amount
+	newStatus := status
+	if newPayments >= charges {
+		newStatus = StatementStatusPaid
+	}
+
+	if _, err := database.DB.ExecContext(ctx,
+		"UPDATE credit_statements SET payments = ?, status = ? WHERE id = ?",
+		newPayments, newStatus, statementID,
+	); err != nil {
+		return fmt.Errorf("更新账单还款状态失败: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package models
+
+import "strings"
+
+// allowedCurrencies 启动时加载的币种白名单，nil表示尚未加载（仅放行默认币种）
+var allowedCurrencies map[string]bool
+
+// LoadCurrencyWhitelist 在启动时加载允许使用的ISO-4217币种白名单
+func LoadCurrencyWhitelist(currencies []string) {
+	whitelist := make(map[string]bool, len(currencies))
+	for _, c := range currencies {
+		whitelist[strings.ToUpper(c)] = true
+	}
+	allowedCurrencies = whitelist
+}
+
+// IsCurrencyAllowed 判断币种是否在白名单内
+func IsCurrencyAllowed(currency string) bool {
+	if allowedCurrencies == nil {
+		return currency == DefaultCurrency
+	}
+	return allowedCurrencies[strings.ToUpper(currency)]
+}
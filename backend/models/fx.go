@@ -0,0 +1,114 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"bamsystem-backend/database"
+)
+
+// FXRate 一次汇率查得的快照
+type FXRate struct {
+	Base      string    `json:"base"`
+	Quote     string    `json:"quote"`
+	Rate      float64   `json:"rate"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// RateProvider 汇率来源的可插拔接口
+type RateProvider interface {
+	GetRate(ctx context.Context, base, quote string) (float64, error)
+}
+
+// StaticRateProvider 使用config.json中配置的静态汇率表，key格式为"BASE/QUOTE"
+type StaticRateProvider struct {
+	rates map[string]float64
+}
+
+// NewStaticRateProvider 根据配置的静态汇率表构建汇率源
+func NewStaticRateProvider(rates map[string]float64) *StaticRateProvider {
+	return &StaticRateProvider{rates: rates}
+}
+
+// GetRate 实现 RateProvider
+func (p *StaticRateProvider) GetRate(ctx context.Context, base, quote string) (float64, error) {
+	if base == quote {
+		return 1, nil
+	}
+	if rate, ok := p.rates[base+"/"+quote]; ok {
+		return rate, nil
+	}
+	if rate, ok := p.rates[quote+"/"+base]; ok && rate != 0 {
+		return 1 / rate, nil
+	}
+	return 0, fmt.Errorf("未配置汇率: %s/%s", base, quote)
+}
+
+// HTTPRateProvider 通过外部HTTP接口获取实时汇率
+type HTTPRateProvider struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+// NewHTTPRateProvider 创建一个请求指定endpoint的汇率源
+func NewHTTPRateProvider(endpoint string) *HTTPRateProvider {
+	return &HTTPRateProvider{
+		Endpoint: endpoint,
+		Client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// GetRate 实现 RateProvider，请求形如 GET {endpoint}?base=USD&quote=CNY，返回 {"rate": ...}
+func (p *HTTPRateProvider) GetRate(ctx context.Context, base, quote string) (float64, error) {
+	url := fmt.Sprintf("%s?base=%s&quote=%s", p.Endpoint, base, quote)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("构造汇率请求失败: %v", err)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("获取汇率失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("获取汇率失败: 状态码 %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Rate float64 `json:"rate"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("解析汇率响应失败: %v", err)
+	}
+
+	return result.Rate, nil
+}
+
+// activeRateProvider 当前生效的汇率源，默认不配置任何静态汇率
+var activeRateProvider RateProvider = NewStaticRateProvider(nil)
+
+// SetRateProvider 替换当前使用的汇率来源，通常在启动时根据配置调用一次
+func SetRateProvider(p RateProvider) {
+	activeRateProvider = p
+}
+
+// GetLatestRate 获取最新汇率，并将快照写入fx_rates表留存审计记录
+func GetLatestRate(ctx context.Context, base, quote string) (float64, error) {
+	rate, err := activeRateProvider.GetRate(ctx, base, quote)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := database.DB.ExecContext(ctx,
+		"INSERT INTO fx_rates (base, quote, rate) VALUES (?, ?, ?)", base, quote, rate,
+	); err != nil {
+		return 0, fmt.Errorf("记录汇率快照失败: %v", err)
+	}
+
+	return rate, nil
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"bamsystem-backend/database"
+)
+
+// idempotencyPendingStatus 标记一条幂等记录仍在处理中，尚未写回真实的响应状态码
+const idempotencyPendingStatus = 0
+
+// IdempotencyRecord 记录一次写操作的响应结果，供相同(client_id, key)重放时直接返回
+type IdempotencyRecord struct {
+	ClientID       string
+	Key            string
+	RequestHash    string
+	ResponseStatus int
+	ResponseBody   []byte
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+}
+
+// IsPending 该记录是否仍在处理中（尚未写回真实响应）
+func (r *IdempotencyRecord) IsPending() bool {
+	return r.ResponseStatus == idempotencyPendingStatus
+}
+
+// ReserveIdempotencyKey 原子地占用一个(client_id, key)并写入占位记录，占用成功返回true。
+// 除全新插入外，也接管已过期但尚未被清理任务回收的旧占位记录（避免处理方异常退出后该key被永久锁死），
+// 命中仍然有效的记录（占用中或已完成）时返回false，由调用方查询当前状态决定如何响应
+func ReserveIdempotencyKey(clientID, key, requestHash string, ttl time.Duration) (bool, error) {
+	res, err := database.DB.Exec(
+		`INSERT INTO idempotency_keys (client_id, `+"`key`"+`, request_hash, response_status, response_body, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?)
+		 ON DUPLICATE KEY UPDATE
+		   request_hash = IF(expires_at < NOW(), VALUES(request_hash), request_hash),
+		   response_status = IF(expires_at < NOW(), VALUES(response_status), response_status),
+		   response_body = IF(expires_at < NOW(), VALUES(response_body), response_body),
+		   expires_at = IF(expires_at < NOW(), VALUES(expires_at), expires_at)`,
+		clientID, key, requestHash, idempotencyPendingStatus, []byte{}, time.Now().Add(ttl),
+	)
+	if err != nil {
+		return false, fmt.Errorf("占用幂等记录失败: %v", err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("获取影响行数失败: %v", err)
+	}
+
+	// MySQL的INSERT...ON DUPLICATE KEY UPDATE：全新插入影响1行，接管一条确实发生变化的过期记录影响2行，
+	// 命中仍然有效（未过期）的记录因UPDATE未实际改变任何列而影响0行
+	return rows == 1 || rows == 2, nil
+}
+
+// GetIdempotencyRecord 按(client_id, key)查询幂等记录；不存在或已过期均视为未命中（返回nil, nil）
+func GetIdempotencyRecord(clientID, key string) (*IdempotencyRecord, error) {
+	row := database.DB.QueryRow(
+		"SELECT client_id, `key`, request_hash, response_status, response_body, created_at, expires_at FROM idempotency_keys WHERE client_id = ? AND `key` = ?",
+		clientID, key,
+	)
+
+	record := &IdempotencyRecord{}
+	if err := row.Scan(
+		&record.ClientID, &record.Key, &record.RequestHash,
+		&record.ResponseStatus, &record.ResponseBody, &record.CreatedAt, &record.ExpiresAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("查询幂等记录失败: %v", err)
+	}
+
+	if time.Now().After(record.ExpiresAt) {
+		return nil, nil
+	}
+
+	return record, nil
+}
+
+// CompleteIdempotencyRecord 将占位记录写回真实的响应结果，并续期至完整的幂等TTL
+func CompleteIdempotencyRecord(clientID, key string, status int, body []byte, ttl time.Duration) error {
+	_, err := database.DB.Exec(
+		"UPDATE idempotency_keys SET response_status = ?, response_body = ?, expires_at = ? WHERE client_id = ? AND `key` = ?",
+		status, body, time.Now().Add(ttl), clientID, key,
+	)
+	if err != nil {
+		return fmt.Errorf("保存幂等记录失败: %v", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey 处理异常退出时释放占位记录，避免(client_id, key)被永久锁死
+func ReleaseIdempotencyKey(clientID, key string) error {
+	_, err := database.DB.Exec(
+		"DELETE FROM idempotency_keys WHERE client_id = ? AND `key` = ? AND response_status = ?",
+		clientID, key, idempotencyPendingStatus,
+	)
+	if err != nil {
+		return fmt.Errorf("释放幂等记录失败: %v", err)
+	}
+	return nil
+}
+
+// PurgeExpiredIdempotencyKeys 清理已过期的幂等记录，返回清理的行数
+func PurgeExpiredIdempotencyKeys() (int64, error) {
+	result, err := database.DB.Exec("DELETE FROM idempotency_keys WHERE expires_at < ?", time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("清理幂等记录失败: %v", err)
+	}
+	return result.RowsAffected()
+}
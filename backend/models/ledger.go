@@ -0,0 +1,301 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"bamsystem-backend/database"
+)
+
+// Direction 记账方向
+type Direction string
+
+const (
+	DirectionDebit  Direction = "debit"
+	DirectionCredit Direction = "credit"
+)
+
+// SystemAccountUUID 用于与外部资金对冲的系统账户（存款的贷方来源/取款的借方去向）
+const SystemAccountUUID = "00000000-0000-0000-0000-000000000000"
+
+// Posting 一条不可变的复式记账分录
+type Posting struct {
+	ID          int64     `json:"id"`
+	TxID        int64     `json:"tx_id"`
+	AccountUUID string    `json:"account_uuid"`
+	Direction   Direction `json:"direction"`
+	Amount      uint64    `json:"amount"`
+	Currency    string    `json:"currency"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Transaction 一笔业务操作，至少包含两条借贷平衡的分录
+type Transaction struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	Memo      string    `json:"memo"`
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Ledger 复式记账账本
+type Ledger struct{}
+
+// NewLedger 创建账本实例
+func NewLedger() *Ledger {
+	return &Ledger{}
+}
+
+// ensureSystemAccount 确保系统对冲账户存在，供存取款的另一条分录使用
+func ensureSystemAccount() error {
+	_, err := database.DB.Exec("INSERT IGNORE INTO accounts (uuid, balance) VALUES (?, 0)", SystemAccountUUID)
+	if err != nil {
+		return fmt.Errorf("初始化系统账户失败: %v", err)
+	}
+	return nil
+}
+
+// affectedAccounts 去重并排序分录涉及的账户，固定加锁顺序以避免转账互锁死锁
+func affectedAccounts(postings []Posting) []string {
+	seen := make(map[string]bool)
+	var uuids []string
+	for _, p := range postings {
+		if !seen[p.AccountUUID] {
+			seen[p.AccountUUID] = true
+			uuids = append(uuids, p.AccountUUID)
+		}
+	}
+	sort.Strings(uuids)
+	return uuids
+}
+
+// PostTransaction 在一个事务内原子地写入一笔交易及其分录
+// 要求每种币种的借贷方向signed金额之和为0，并对涉及的账户加行锁防止并发丢失更新
+func (l *Ledger) PostTransaction(ctx context.Context, kind, memo, requestID string, postings []Posting) (int64, error) {
+	if len(postings) < 2 {
+		return 0, fmt.Errorf("交易至少需要2条分录")
+	}
+
+	sums := make(map[string]int64)
+	for _, p := range postings {
+		switch p.Direction {
+		case DirectionDebit:
+			sums[p.Currency] -= int64(p.Amount)
+		case DirectionCredit:
+			sums[p.Currency] += int64(p.Amount)
+		default:
+			return 0, fmt.Errorf("无效的记账方向: %s", p.Direction)
+		}
+	}
+	for currency, sum := range sums {
+		if sum != 0 {
+			return 0, fmt.Errorf("币种%s借贷不平衡: %d", currency, sum)
+		}
+	}
+
+	tx, err := database.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("开始事务失败: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// 按固定顺序对涉及的账户加行锁，避免并发记账导致余额丢失更新
+	for _, uuid := range affectedAccounts(postings) {
+		var locked string
+		if err = tx.QueryRowContext(ctx, "SELECT uuid FROM accounts WHERE uuid = ? FOR UPDATE", uuid).Scan(&locked); err != nil {
+			if err == sql.ErrNoRows {
+				err = fmt.Errorf("账户不存在: %s", uuid)
+			} else {
+				err = fmt.Errorf("锁定账户失败: %v", err)
+			}
+			return 0, err
+		}
+	}
+
+	// 账户行锁已持有，此时重新核算余额/透支约束才是权威的；锁前的校验在并发请求下会读到过期余额
+	if err = validateAccountBalances(ctx, tx, postings); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO transactions (kind, memo, request_id) VALUES (?, ?, ?)", kind, memo, requestID)
+	if err != nil {
+		err = fmt.Errorf("创建交易失败: %v", err)
+		return 0, err
+	}
+
+	txID, err := res.LastInsertId()
+	if err != nil {
+		err = fmt.Errorf("获取交易ID失败: %v", err)
+		return 0, err
+	}
+
+	for _, p := range postings {
+		if _, err = tx.ExecContext(ctx,
+			"INSERT INTO postings (tx_id, account_uuid, direction, amount, currency) VALUES (?, ?, ?, ?, ?)",
+			txID, p.AccountUUID, p.Direction, p.Amount, p.Currency,
+		); err != nil {
+			err = fmt.Errorf("写入分录失败: %v", err)
+			return 0, err
+		}
+
+		delta := int64(p.Amount)
+		if p.Direction == DirectionDebit {
+			delta = -delta
+		}
+
+		if _, err = tx.ExecContext(ctx,
+			"INSERT INTO account_balances (uuid, currency, balance) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE balance = balance + ?",
+			p.AccountUUID, p.Currency, delta, delta,
+		); err != nil {
+			err = fmt.Errorf("更新币种余额缓存失败: %v", err)
+			return 0, err
+		}
+
+		// accounts.balance 是默认币种的历史遗留缓存字段，其余币种只在 account_balances 中维护
+		if p.Currency == DefaultCurrency {
+			if _, err = tx.ExecContext(ctx, "UPDATE accounts SET balance = balance + ? WHERE uuid = ?", delta, p.AccountUUID); err != nil {
+				err = fmt.Errorf("更新余额缓存失败: %v", err)
+				return 0, err
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		err = fmt.Errorf("提交事务失败: %v", err)
+		return 0, err
+	}
+
+	return txID, nil
+}
+
+// validateAccountBalances 在账户行锁已持有的前提下，按账户+币种汇总本次交易的净变动，
+// 校验变动后的余额是否违反余额充足/信用额度约束；系统对冲账户不受此约束
+func validateAccountBalances(ctx context.Context, tx *sql.Tx, postings []Posting) error {
+	type accountCurrency struct {
+		uuid     string
+		currency string
+	}
+	deltas := make(map[accountCurrency]int64)
+	for _, p := range postings {
+		if p.AccountUUID == SystemAccountUUID {
+			continue
+		}
+		delta := int64(p.Amount)
+		if p.Direction == DirectionDebit {
+			delta = -delta
+		}
+		deltas[accountCurrency{p.AccountUUID, p.Currency}] += delta
+	}
+
+	for ac, delta := range deltas {
+		if delta >= 0 {
+			continue
+		}
+
+		var balance int64
+		err := tx.QueryRowContext(ctx, "SELECT balance FROM account_balances WHERE uuid = ? AND currency = ?", ac.uuid, ac.currency).Scan(&balance)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("查询账户余额失败: %v", err)
+		}
+
+		if err := checkOverdraft(ac.uuid, ac.currency, balance, uint64(-delta)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetHistory 按游标分页查询账户在[from, to]区间内的流水
+func (l *Ledger) GetHistory(accountUUID string, from, to time.Time, limit int, cursor string) ([]Posting, string, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	var afterID int64
+	if cursor != "" {
+		id, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("cursor格式错误")
+		}
+		afterID = id
+	}
+
+	query := `
+		SELECT id, tx_id, account_uuid, direction, amount, currency, created_at
+		FROM postings
+		WHERE account_uuid = ? AND created_at >= ? AND created_at <= ? AND id > ?
+		ORDER BY id ASC
+		LIMIT ?
+	`
+	rows, err := database.DB.Query(query, accountUUID, from, to, afterID, limit)
+	if err != nil {
+		return nil, "", fmt.Errorf("查询流水失败: %v", err)
+	}
+	defer rows.Close()
+
+	var postings []Posting
+	for rows.Next() {
+		var p Posting
+		if err := rows.Scan(&p.ID, &p.TxID, &p.AccountUUID, &p.Direction, &p.Amount, &p.Currency, &p.CreatedAt); err != nil {
+			return nil, "", fmt.Errorf("扫描流水数据失败: %v", err)
+		}
+		postings = append(postings, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("遍历流水数据失败: %v", err)
+	}
+
+	nextCursor := ""
+	if len(postings) == limit {
+		nextCursor = strconv.FormatInt(postings[len(postings)-1].ID, 10)
+	}
+
+	return postings, nextCursor, nil
+}
+
+// GetTransaction 查询单笔交易及其全部分录
+func (l *Ledger) GetTransaction(txID int64) (*Transaction, []Posting, error) {
+	row := database.DB.QueryRow("SELECT id, kind, memo, request_id, created_at FROM transactions WHERE id = ?", txID)
+
+	t := &Transaction{}
+	var requestID sql.NullString
+	if err := row.Scan(&t.ID, &t.Kind, &t.Memo, &requestID, &t.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, fmt.Errorf("交易不存在")
+		}
+		return nil, nil, fmt.Errorf("查询交易失败: %v", err)
+	}
+	t.RequestID = requestID.String
+
+	rows, err := database.DB.Query(
+		"SELECT id, tx_id, account_uuid, direction, amount, currency, created_at FROM postings WHERE tx_id = ? ORDER BY id ASC",
+		txID,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("查询分录失败: %v", err)
+	}
+	defer rows.Close()
+
+	var postings []Posting
+	for rows.Next() {
+		var p Posting
+		if err := rows.Scan(&p.ID, &p.TxID, &p.AccountUUID, &p.Direction, &p.Amount, &p.Currency, &p.CreatedAt); err != nil {
+			return nil, nil, fmt.Errorf("扫描分录数据失败: %v", err)
+		}
+		postings = append(postings, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("遍历分录数据失败: %v", err)
+	}
+
+	return t, postings, nil
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bamsystem-backend/database"
+)
+
+// TestPostTransaction_ConcurrentWithdrawals 验证PostTransaction对涉及账户加的行锁能防止并发丢失更新：
+// 多个goroutine同时对同一账户发起等额取款，总取款额恰好等于初始余额时应全部成功且余额精确归零，
+// 再多一笔取款则必须因余额不足被拒绝。若FOR UPDATE锁失效，并发请求会读到同一份过期余额，
+// 导致最终余额不为0（多扣）或超额取款被错误放行（少扣）。
+//
+// 需要设置环境变量 BAMSYSTEM_TEST_DSN 指向一个可用于测试的MySQL实例，未设置时跳过本测试。
+func TestPostTransaction_ConcurrentWithdrawals(t *testing.T) {
+	dsn := os.Getenv("BAMSYSTEM_TEST_DSN")
+	if dsn == "" {
+		t.Skip("未设置BAMSYSTEM_TEST_DSN，跳过需要真实MySQL实例的并发测试")
+	}
+
+	if err := database.InitDB(dsn); err != nil {
+		t.Fatalf("初始化测试数据库失败: %v", err)
+	}
+
+	const concurrency = 20
+	const amountEach uint64 = 1000
+	// accounts.uuid 等列为VARCHAR(36)，测试账户ID需控制在36个字符以内
+	uuid := fmt.Sprintf("test-ledger-%d", time.Now().UnixNano())
+
+	if err := CreateAccount(uuid, amountEach*uint64(concurrency), DefaultCurrency); err != nil {
+		t.Fatalf("创建测试账户失败: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := database.DB.Exec("DELETE FROM accounts WHERE uuid = ?", uuid); err != nil {
+			t.Logf("清理测试账户失败: %v", err)
+		}
+		if _, err := database.DB.Exec("DELETE FROM account_balances WHERE uuid = ?", uuid); err != nil {
+			t.Logf("清理测试账户余额失败: %v", err)
+		}
+	})
+
+	var wg sync.WaitGroup
+	var succeeded int64
+	errs := make(chan error, concurrency)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Withdraw(uuid, amountEach, DefaultCurrency); err != nil {
+				errs <- err
+				return
+			}
+			atomic.AddInt64(&succeeded, 1)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("并发取款应全部成功，但出现错误: %v", err)
+	}
+	if succeeded != concurrency {
+		t.Fatalf("期望%d笔取款全部成功，实际成功%d笔", concurrency, succeeded)
+	}
+
+	finalBalance, err := GetAccountBalance(uuid, DefaultCurrency)
+	if err != nil {
+		t.Fatalf("查询最终余额失败: %v", err)
+	}
+	if finalBalance != 0 {
+		t.Fatalf("并发取款后余额应精确归零（行锁失效会导致丢失更新），实际为%d", finalBalance)
+	}
+
+	if _, err := Withdraw(uuid, 1, DefaultCurrency); err == nil {
+		t.Fatal("余额已耗尽，多余的一笔取款本应因余额不足被拒绝")
+	}
+}